@@ -0,0 +1,144 @@
+// Package acme wraps golang.org/x/crypto/acme/autocert to obtain and renew
+// certificates from an ACME CA (typically Let's Encrypt) and keeps the
+// matching TLSA records published via Dynamic Update, so that a tlsa-based
+// tool can run unattended as a long-lived daemon instead of a one-shot CLI.
+package acme
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nerdlem/tlsa"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Manager obtains and renews certificates for a set of hostnames via ACME,
+// and republishes the corresponding TLSA record every time a new leaf
+// certificate becomes available.
+type Manager struct {
+	// HostPolicy restricts the hostnames for which certificates may be
+	// requested. Use autocert.HostWhitelist for a fixed list of names.
+	HostPolicy autocert.HostPolicy
+
+	// CacheDir is the directory where the wrapped autocert.Manager
+	// persists issued certificates and its account key.
+	CacheDir string
+
+	// Email is the contact address registered with the ACME CA.
+	Email string
+
+	// Provider dispatches the Dynamic Updates that publish TLSA records,
+	// the same as the CLI's --provider flag -- it need not be classic
+	// TSIG.
+	Provider tlsa.Provider
+
+	// PollInterval controls how often the certificate cache is checked
+	// for renewals. Defaults to one hour when zero.
+	PollInterval time.Duration
+
+	autocert *autocert.Manager
+	sigs     map[string]string
+}
+
+// NewManager builds a Manager, along with the autocert.Manager it wraps. The
+// returned Manager's TLSConfig can be plugged directly into an http.Server.
+func NewManager(hostPolicy autocert.HostPolicy, cacheDir, email string, provider tlsa.Provider) *Manager {
+	m := &Manager{
+		HostPolicy: hostPolicy,
+		CacheDir:   cacheDir,
+		Email:      email,
+		Provider:   provider,
+		sigs:       make(map[string]string),
+	}
+
+	m.autocert = &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: hostPolicy,
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+	}
+
+	return m
+}
+
+// TLSConfig returns the tls.Config to use when serving HTTPS, backed by the
+// wrapped autocert.Manager. Obtaining a certificate this way answers the
+// ACME TLS-ALPN-01 challenge, so a listener using it needs no separate
+// HTTP-01 responder.
+func (m *Manager) TLSConfig() *tls.Config {
+	return m.autocert.TLSConfig()
+}
+
+// HTTPHandler returns the handler that answers the ACME HTTP-01 challenge
+// for the hosts this Manager manages, falling back to fallback (or a
+// redirect to HTTPS, if fallback is nil) for any other request. Without
+// this handler served on port 80 -- or a TLS-ALPN-01 listener using
+// TLSConfig -- Let's Encrypt has no way to validate domain ownership and
+// every certificate request will fail.
+func (m *Manager) HTTPHandler(fallback http.Handler) http.Handler {
+	return m.autocert.HTTPHandler(fallback)
+}
+
+// Watch polls the certificate cache for the given hosts and republishes the
+// matching TLSA record whenever a new leaf certificate is issued or
+// renewed. It blocks, so it's meant to be run in its own goroutine for the
+// lifetime of the daemon. Watch alone does not satisfy ACME domain
+// validation -- run HTTPHandler on :80 or serve TLSConfig before calling
+// Watch, or certificate issuance will never succeed.
+func (m *Manager) Watch(hosts []string) {
+	interval := m.PollInterval
+	if interval == 0 {
+		interval = time.Hour
+	}
+
+	for {
+		for _, host := range hosts {
+			if err := m.publish(host); err != nil {
+				log.Printf("acme: failed to publish TLSA for %s: %s", host, err)
+			}
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// publish computes the TLSA signature for the certificate currently cached
+// for host and dispatches a Dynamic Update if it differs from the last one
+// published for that host.
+func (m *Manager) publish(host string) error {
+	cert, err := m.autocert.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+	if err != nil {
+		return fmt.Errorf("no certificate cached yet for %s: %s", host, err)
+	}
+
+	leaf := cert.Leaf
+	if leaf == nil {
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("failed to parse certificate for %s: %s", host, err)
+		}
+	}
+
+	sig, err := dns.CertificateToDANE(uint8(tlsa.Selector), uint8(tlsa.MatchingType), leaf)
+	if err != nil {
+		return fmt.Errorf("failed to compute TLSA signature for %s: %s", host, err)
+	}
+
+	if m.sigs[host] == sig {
+		return nil
+	}
+
+	if err := tlsa.AddRR(context.Background(), []string{host},
+		m.Provider, []string{sig}, 1); err != nil {
+		return err
+	}
+	m.sigs[host] = sig
+
+	return nil
+}
@@ -1,8 +1,12 @@
 package tlsa
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"github.com/miekg/dns"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -28,9 +32,71 @@ var MatchingType = uint(3)
 // NameServer is the Global Name Server to use for sending the updates.
 var NameServer = "127.0.0.1:53"
 
+// Transport selects the network dns.Client uses to reach NameServer: "udp"
+// (the default), "tcp", or "tcp-tls" for DNS-over-TLS -- useful when the
+// hidden master lives across an untrusted network.
+var Transport = "udp"
+
+// TLSConfig is used for the handshake when Transport is "tcp-tls".
+var TLSConfig *tls.Config
+
+// Concurrency is the default number of domains AddRR and DeleteRRs process
+// in parallel when called with a concurrency of 0 or less.
+var Concurrency = 4
+
+// newDNSClient builds a dns.Client configured for the current Transport.
+func newDNSClient() *dns.Client {
+	c := new(dns.Client)
+	c.Net = Transport
+
+	if Transport == "tcp-tls" {
+		c.TLSConfig = TLSConfig
+	}
+
+	return c
+}
+
+// DomainError pairs a domain name with the error encountered while
+// processing it, as collected by AddRR and DeleteRRs.
+type DomainError struct {
+	Domain string
+	Err    error
+}
+
+func (e *DomainError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Domain, e.Err)
+}
+
+// AggregateError collects the per-domain failures from a concurrent AddRR
+// or DeleteRRs run, instead of the panic() the previous sequential
+// implementation resorted to on the first failure.
+type AggregateError struct {
+	Errors []DomainError
+}
+
+func (e *AggregateError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for _, de := range e.Errors {
+		msgs = append(msgs, de.Error())
+	}
+
+	return fmt.Sprintf("%d domain(s) failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// aggregate turns a slice of per-domain failures into an error, or nil if
+// the slice is empty.
+func aggregate(errs []DomainError) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return &AggregateError{Errors: errs}
+}
+
 // TsigAndSend signs a composed DNS message (dns.Msg) and sends it using the
-// global name server configured via NameServer.
-func TsigAndSend(m *dns.Msg, keys []dns.KEY) error {
+// global name server configured via NameServer, over the transport
+// configured via Transport.
+func TsigAndSend(ctx context.Context, m *dns.Msg, keys []dns.KEY) error {
 
 	if m.Id == 0 {
 		m.Id = dns.Id()
@@ -64,10 +130,10 @@ func TsigAndSend(m *dns.Msg, keys []dns.KEY) error {
 
 		m.SetTsig(t.Hdr.Name, algo, TSIGFUDGE, time.Now().Unix())
 
-		c := new(dns.Client)
+		c := newDNSClient()
 		c.TsigSecret = map[string]string{t.Hdr.Name: t.PublicKey}
 
-		in, _, err := c.Exchange(m, NameServer)
+		in, _, err := c.ExchangeContext(ctx, m, NameServer)
 		if err != nil {
 			return fmt.Errorf("error processing records via %s: %s",
 				NameServer, err)
@@ -84,61 +150,65 @@ func TsigAndSend(m *dns.Msg, keys []dns.KEY) error {
 
 // GetZone finds the apex where the updated name is located at. A SOA DNS
 // query is sent to the global Name Server -- expected to be the (possibly
-// hidden) master server managing this zone's data.
-func GetZone(name string, ns string) (string, error) {
+// hidden) master server managing this zone's data. Failed attempts are
+// retried with exponential backoff until ctx is done, rather than giving up
+// after a fixed number of tries.
+func GetZone(ctx context.Context, name string, ns string) (string, error) {
 
-	// Perform an initial query to assert the SOA corresponding to the name
+	c := newDNSClient()
 
-	c := new(dns.Client)
+	backoff := 100 * time.Millisecond
+	const maxBackoff = 5 * time.Second
 
-	for attempt := 0; attempt < 5; attempt++ {
+	for {
 		m := new(dns.Msg)
 		m.Id = dns.Id()
 		m.SetQuestion(dns.Fqdn(name), dns.TypeSOA)
 		m.SetEdns0(UDPBUFSIZE, true)
 
-		in, rtt, err := c.Exchange(m, NameServer)
+		in, _, err := c.ExchangeContext(ctx, m, NameServer)
 		if err != nil {
-			fmt.Printf("error processing records via %s (rtt %d): %s\n",
-				NameServer, rtt, err)
+			select {
+			case <-ctx.Done():
+				return ".", fmt.Errorf(
+					"giving up getting SOA for %s: %s", name, ctx.Err())
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
 			continue
 		}
 
 		// Identify the SOA RR and respond with its name
 
-		for _, rr := range in.Ns {
+		for _, rr := range append(append([]dns.RR{}, in.Ns...), in.Answer...) {
 			h := rr.Header()
 			if h.Class == dns.ClassINET && h.Rrtype == dns.TypeSOA {
 				return h.Name, nil
 			}
 		}
 
-		for _, rr := range in.Answer {
-			h := rr.Header()
-			if h.Class == dns.ClassINET && h.Rrtype == dns.TypeSOA {
-				return h.Name, nil
-			}
-		}
-
-		panic(fmt.Sprintf(
-			"SOA response for %s had no usable authority records",
-			name))
+		return ".", fmt.Errorf(
+			"SOA response for %s had no usable authority records", name)
 	}
-
-	return ".", fmt.Errorf(
-		"too many unsuccessful attempts to get SOA for %s",
-		name)
 }
 
-// DeleteRRs composes a DNS Dynamic Update to delete all TLSA RRs. This can be
-// used to wipe clean the namespace. Uses the TsigAndSend() helper to cause
-// the update to be sent to the global Name Server for processing.
-func DeleteRRs(pinNames []string, keys []dns.KEY) {
-	for _, domain := range pinNames {
-
-		zone, err := GetZone(domain, NameServer)
+// DeleteRRs composes a DNS Dynamic Update to delete all TLSA RRs for each of
+// pinNames. This can be used to wipe clean the namespace. Up to concurrency
+// domains (Concurrency, when concurrency is 0 or less) are processed in
+// parallel, the update for each dispatched via the given Provider. Instead
+// of panicking on the first failure, it returns an *AggregateError mapping
+// every failed domain to its error; ctx bounds how long each domain's
+// lookups and update are allowed to take.
+func DeleteRRs(ctx context.Context, pinNames []string, provider Provider, concurrency int) error {
+	return forEachDomain(ctx, pinNames, concurrency, func(ctx context.Context, domain string) error {
+		zone, err := GetZone(ctx, domain, NameServer)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		m := new(dns.Msg)
@@ -157,21 +227,22 @@ func DeleteRRs(pinNames []string, keys []dns.KEY) {
 
 		m.RemoveRRset(records)
 
-		err = TsigAndSend(m, keys)
-		if err != nil {
-			panic(err)
-		}
-	}
+		return provider.Apply(ctx, zone, m)
+	})
 }
 
-// AddRR composes a DNS Dynamic Updte to add one or more TLSA RR. The process
-// is meant to be additive, so that multiple records can be appended. The
-// update request is sent via the TsigAndSend() helper.
-func AddRR(pinNames []string, keys []dns.KEY, crtSigns []string) {
-	for _, domain := range pinNames {
-		zone, err := GetZone(domain, NameServer)
+// AddRR composes a DNS Dynamic Updte to add one or more TLSA RR for each of
+// pinNames. The process is meant to be additive, so that multiple records
+// can be appended. Up to concurrency domains (Concurrency, when concurrency
+// is 0 or less) are processed in parallel, the update for each dispatched
+// via the given Provider. Instead of panicking on the first failure, it
+// returns an *AggregateError mapping every failed domain to its error; ctx
+// bounds how long each domain's lookups and update are allowed to take.
+func AddRR(ctx context.Context, pinNames []string, provider Provider, crtSigns []string, concurrency int) error {
+	return forEachDomain(ctx, pinNames, concurrency, func(ctx context.Context, domain string) error {
+		zone, err := GetZone(ctx, domain, NameServer)
 		if err != nil {
-			panic(err)
+			return err
 		}
 
 		records := make([]dns.RR, 0, 1)
@@ -193,11 +264,51 @@ func AddRR(pinNames []string, keys []dns.KEY, crtSigns []string) {
 		m.SetUpdate(zone)
 		m.Insert(records)
 
-		err = TsigAndSend(m, keys)
-		if err != nil {
-			panic(err)
+		return provider.Apply(ctx, zone, m)
+	})
+}
+
+// forEachDomain runs fn for every domain in pinNames, using up to
+// concurrency (Concurrency when 0 or less) goroutines, and aggregates the
+// per-domain errors it returns.
+func forEachDomain(ctx context.Context, pinNames []string, concurrency int, fn func(ctx context.Context, domain string) error) error {
+	if concurrency <= 0 {
+		concurrency = Concurrency
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []DomainError
+		sem  = make(chan struct{}, concurrency)
+	)
+
+	for _, domain := range pinNames {
+		if err := ctx.Err(); err != nil {
+			mu.Lock()
+			errs = append(errs, DomainError{Domain: domain, Err: err})
+			mu.Unlock()
+			continue
 		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			if err := fn(ctx, domain); err != nil {
+				mu.Lock()
+				errs = append(errs, DomainError{Domain: domain, Err: err})
+				mu.Unlock()
+			}
+		}(domain)
 	}
+
+	wg.Wait()
+
+	return aggregate(errs)
 }
 
 // CertificateSignatures precalculates the certificate signatures from the
@@ -0,0 +1,259 @@
+package tlsa
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"github.com/miekg/dns"
+	"net"
+	"strings"
+	"time"
+)
+
+// starttlsPorts maps the well-known ports of STARTTLS-capable services to
+// the protocol GetCertificateFromEndpoint should speak before the TLS
+// handshake, mirroring how DANE-for-SMTP (and IMAP/XMPP) deployments are
+// commonly verified in the wild. Ports that carry TLS directly on the
+// socket (465, 993) are intentionally absent.
+var starttlsPorts = map[string]string{
+	"25":   "smtp",
+	"587":  "smtp",
+	"143":  "imap",
+	"5222": "xmpp",
+}
+
+// endpointConfig holds the options a DialOption can tweak.
+type endpointConfig struct {
+	serverName string
+	startTLS   string
+	timeout    time.Duration
+}
+
+// DialOption configures how GetCertificateFromEndpoint connects to a live
+// TLS endpoint.
+type DialOption func(*endpointConfig)
+
+// WithServerName overrides the SNI host name sent during the TLS handshake.
+// Defaults to the host portion of the hostport passed to
+// GetCertificateFromEndpoint.
+func WithServerName(name string) DialOption {
+	return func(c *endpointConfig) { c.serverName = name }
+}
+
+// WithSTARTTLS selects the plaintext-to-TLS upgrade protocol to speak
+// before the handshake, for services that don't negotiate TLS directly on
+// the socket. One of "smtp", "imap" or "xmpp". GetCertificateFromEndpoint
+// already picks this automatically from well-known ports; use this option
+// to override that guess.
+func WithSTARTTLS(proto string) DialOption {
+	return func(c *endpointConfig) { c.startTLS = proto }
+}
+
+// WithTimeout bounds how long GetCertificateFromEndpoint waits for the
+// connection, any STARTTLS negotiation, and the TLS handshake to complete.
+// Defaults to 10 seconds.
+func WithTimeout(d time.Duration) DialOption {
+	return func(c *endpointConfig) { c.timeout = d }
+}
+
+// GetCertificateFromEndpoint connects to hostport and retrieves the leaf
+// certificate presented by the remote end during the TLS handshake. This
+// allows pinning TLSA records for services whose certificates aren't
+// available on the local filesystem, e.g. remote MTAs or load balancers
+// terminating TLS elsewhere.
+func GetCertificateFromEndpoint(hostport string, opts ...DialOption) (*x509.Certificate, error) {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return nil, fmt.Errorf("invalid host:port %s: %s", hostport, err)
+	}
+
+	cfg := &endpointConfig{
+		serverName: host,
+		startTLS:   starttlsPorts[port],
+		timeout:    10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	conn, err := net.DialTimeout("tcp", hostport, cfg.timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %s", hostport, err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(cfg.timeout))
+
+	if cfg.startTLS != "" {
+		if err := negotiateSTARTTLS(conn, cfg.startTLS, cfg.serverName); err != nil {
+			return nil, fmt.Errorf("STARTTLS negotiation with %s failed: %s",
+				hostport, err)
+		}
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: cfg.serverName})
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, fmt.Errorf("TLS handshake with %s failed: %s", hostport, err)
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s presented no certificates", hostport)
+	}
+
+	return certs[0], nil
+}
+
+// negotiateSTARTTLS speaks the plaintext preamble required to ask a
+// STARTTLS-capable service to upgrade the connection to TLS.
+func negotiateSTARTTLS(conn net.Conn, proto, serverName string) error {
+	switch proto {
+	case "smtp":
+		return starttlsSMTP(conn)
+	case "imap":
+		return starttlsIMAP(conn)
+	case "xmpp":
+		return starttlsXMPP(conn, serverName)
+	default:
+		return fmt.Errorf("unsupported STARTTLS protocol %q", proto)
+	}
+}
+
+func starttlsSMTP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if _, err := readSMTPReply(r); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "EHLO tlsa\r\n"); err != nil {
+		return err
+	}
+	if _, err := readSMTPReply(r); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "STARTTLS\r\n"); err != nil {
+		return err
+	}
+
+	code, err := readSMTPReply(r)
+	if err != nil {
+		return err
+	}
+	if code != 220 {
+		return fmt.Errorf("unexpected STARTTLS response code %d", code)
+	}
+
+	return nil
+}
+
+// readSMTPReply reads a (possibly multi-line) SMTP reply and returns its
+// status code.
+func readSMTPReply(r *bufio.Reader) (int, error) {
+	var code int
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return 0, err
+		}
+
+		if len(line) < 4 {
+			return 0, fmt.Errorf("malformed SMTP reply %q", line)
+		}
+
+		if _, err := fmt.Sscanf(line[:3], "%d", &code); err != nil {
+			return 0, fmt.Errorf("malformed SMTP reply code %q", line[:3])
+		}
+
+		if line[3] == ' ' {
+			return code, nil
+		}
+	}
+}
+
+func starttlsIMAP(conn net.Conn) error {
+	r := bufio.NewReader(conn)
+
+	if _, err := r.ReadString('\n'); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn, "a1 STARTTLS\r\n"); err != nil {
+		return err
+	}
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasPrefix(line, "a1 OK"):
+			return nil
+		case strings.HasPrefix(line, "a1 "):
+			return fmt.Errorf("unexpected STARTTLS response: %s",
+				strings.TrimSpace(line))
+		}
+	}
+}
+
+func starttlsXMPP(conn net.Conn, serverName string) error {
+	if _, err := fmt.Fprintf(conn, "<?xml version='1.0'?>"+
+		"<stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>",
+		serverName); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	if _, err := conn.Read(buf); err != nil {
+		return err
+	}
+
+	if _, err := fmt.Fprint(conn,
+		"<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+
+	n, err := conn.Read(buf)
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Contains(buf[:n], []byte("proceed")) {
+		return fmt.Errorf("XMPP server did not proceed with STARTTLS")
+	}
+
+	return nil
+}
+
+// CertificateSignaturesFromEndpoints is the live-endpoint counterpart of
+// CertificateSignatures: it connects to each hostport, retrieves the
+// certificate currently presented there, and computes its TLSA signature
+// using the current Selector/MatchingType.
+func CertificateSignaturesFromEndpoints(hostports []string, opts ...DialOption) ([]string, error) {
+	sigs := make([]string, 0, len(hostports))
+
+	for _, hostport := range hostports {
+		c, err := GetCertificateFromEndpoint(hostport, opts...)
+		if err != nil {
+			return nil, err
+		}
+
+		h, err := dns.CertificateToDANE(uint8(Selector), uint8(MatchingType), c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compute TLSA signature for %s: %s",
+				hostport, err)
+		}
+
+		sigs = append(sigs, h)
+	}
+
+	return sigs, nil
+}
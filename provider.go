@@ -0,0 +1,29 @@
+package tlsa
+
+import (
+	"context"
+	"github.com/miekg/dns"
+)
+
+// Provider abstracts the backend that applies a composed Dynamic Update
+// message to the authoritative DNS infrastructure. AddRR, DeleteRRs and
+// RolloverRRs all go through a Provider, so any backend -- classic TSIG,
+// GSS-TSIG, a cloud DNS API, or a file kept for offline review -- benefits
+// uniformly from the same call sites.
+type Provider interface {
+	// Apply sends msg, which targets zone, to the backend.
+	Apply(ctx context.Context, zone string, msg *dns.Msg) error
+}
+
+// TSIGProvider applies updates by signing them with one or more TSIG keys
+// and sending them to NameServer. This is the original, and still default,
+// way tlsa talks to a hidden master.
+type TSIGProvider struct {
+	// Keys are the TSIG keys to sign the update with.
+	Keys []dns.KEY
+}
+
+// Apply implements Provider.
+func (p TSIGProvider) Apply(ctx context.Context, zone string, msg *dns.Msg) error {
+	return TsigAndSend(ctx, msg, p.Keys)
+}
@@ -0,0 +1,119 @@
+// Package cloudflare implements a tlsa.Provider that applies TLSA updates
+// via the Cloudflare API, for zones managed through Cloudflare DNS instead
+// of a classic TSIG-speaking hidden master.
+package cloudflare
+
+import (
+	"context"
+	"fmt"
+
+	cf "github.com/cloudflare/cloudflare-go"
+	"github.com/miekg/dns"
+)
+
+// Provider applies TLSA records to a single Cloudflare zone.
+type Provider struct {
+	// ZoneID is the Cloudflare zone ID the records live in.
+	ZoneID string
+
+	// TTL is used for created/updated records, in seconds. 1 means
+	// "automatic" in the Cloudflare API.
+	TTL int
+
+	api *cf.API
+}
+
+// NewProvider builds a Provider authenticated with the given API token.
+func NewProvider(apiToken, zoneID string, ttl int) (*Provider, error) {
+	api, err := cf.NewWithAPIToken(apiToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Cloudflare client: %s", err)
+	}
+
+	return &Provider{ZoneID: zoneID, TTL: ttl, api: api}, nil
+}
+
+// Apply implements tlsa.Provider.
+func (p *Provider) Apply(ctx context.Context, zone string, msg *dns.Msg) error {
+	for _, rr := range msg.Ns {
+		t, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+
+		if rr.Header().Class == dns.ClassNONE || rr.Header().Class == dns.ClassANY {
+			if err := p.deleteRecord(ctx, t); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := p.upsertRecord(ctx, t); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) upsertRecord(ctx context.Context, t *dns.TLSA) error {
+	existing, err := p.findRecords(ctx, t.Hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	data := map[string]interface{}{
+		"usage":         t.Usage,
+		"selector":      t.Selector,
+		"matching_type": t.MatchingType,
+		"certificate":   t.Certificate,
+	}
+
+	if len(existing) != 0 {
+		_, err := p.api.UpdateDNSRecord(ctx, cf.ZoneIdentifier(p.ZoneID),
+			cf.UpdateDNSRecordParams{
+				ID:   existing[0].ID,
+				Type: "TLSA",
+				Name: t.Hdr.Name,
+				TTL:  p.TTL,
+				Data: data,
+			})
+		return err
+	}
+
+	_, err = p.api.CreateDNSRecord(ctx, cf.ZoneIdentifier(p.ZoneID),
+		cf.CreateDNSRecordParams{
+			Type: "TLSA",
+			Name: t.Hdr.Name,
+			TTL:  p.TTL,
+			Data: data,
+		})
+
+	return err
+}
+
+func (p *Provider) deleteRecord(ctx context.Context, t *dns.TLSA) error {
+	existing, err := p.findRecords(ctx, t.Hdr.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, r := range existing {
+		if err := p.api.DeleteDNSRecord(ctx, cf.ZoneIdentifier(p.ZoneID), r.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Provider) findRecords(ctx context.Context, name string) ([]cf.DNSRecord, error) {
+	records, _, err := p.api.ListDNSRecords(ctx, cf.ZoneIdentifier(p.ZoneID),
+		cf.ListDNSRecordsParams{Type: "TLSA", Name: name})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Cloudflare records for %s: %s",
+			name, err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,43 @@
+// Package file implements a tlsa.Provider that writes the nsupdate(1)
+// equivalent of each applied message to a file, for offline review instead
+// of sending Dynamic Updates live.
+package file
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+	"os"
+)
+
+// Provider appends the nsupdate script equivalent to each Apply() call to
+// Path, so an operator can review the pending changes (or feed the file to
+// nsupdate by hand) before anything actually reaches the wire.
+type Provider struct {
+	// Path is the file updates are appended to.
+	Path string
+}
+
+// Apply implements tlsa.Provider.
+func (p Provider) Apply(ctx context.Context, zone string, msg *dns.Msg) error {
+	f, err := os.OpenFile(p.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %s", p.Path, err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "zone %s\n", zone)
+
+	for _, rr := range msg.Ns {
+		switch rr.Header().Class {
+		case dns.ClassNONE, dns.ClassANY:
+			fmt.Fprintf(f, "update delete %s\n", rr.String())
+		default:
+			fmt.Fprintf(f, "update add %s\n", rr.String())
+		}
+	}
+
+	fmt.Fprint(f, "send\n\n")
+
+	return nil
+}
@@ -0,0 +1,78 @@
+// Package gsstsig implements a tlsa.Provider that authenticates Dynamic
+// Updates with GSS-TSIG (Kerberos), as required by Active Directory-
+// integrated DNS servers that don't accept classic shared-secret TSIG keys.
+package gsstsig
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bodgit/tsig"
+	"github.com/bodgit/tsig/gss"
+	"github.com/miekg/dns"
+)
+
+// Provider applies updates signed with a GSS-TSIG security context
+// negotiated with a single DNS server.
+type Provider struct {
+	// Server is the target DNS server, host:port.
+	Server string
+
+	client  *gss.Client
+	keyName string
+	keyExp  time.Time
+}
+
+// NewProvider negotiates a GSS-TSIG security context with server, under
+// whatever Kerberos credentials are available to the process (typically
+// the default ccache), and returns a Provider ready to Apply() updates.
+func NewProvider(server string) (*Provider, error) {
+	client, err := gss.NewClient(new(dns.Client))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GSS-TSIG client: %s", err)
+	}
+
+	keyName, exp, err := client.NegotiateContext(server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to negotiate GSS-TSIG context with %s: %s",
+			server, err)
+	}
+
+	return &Provider{Server: server, client: client, keyName: keyName, keyExp: exp}, nil
+}
+
+// Close releases the negotiated GSS-TSIG security context.
+func (p *Provider) Close() error {
+	return p.client.DeleteContext(p.keyName)
+}
+
+// Apply implements tlsa.Provider.
+func (p *Provider) Apply(ctx context.Context, zone string, msg *dns.Msg) error {
+	if time.Now().After(p.keyExp) {
+		keyName, exp, err := p.client.NegotiateContext(p.Server)
+		if err != nil {
+			return fmt.Errorf("failed to renegotiate GSS-TSIG context with %s: %s",
+				p.Server, err)
+		}
+		p.keyName, p.keyExp = keyName, exp
+	}
+
+	msg.SetTsig(p.keyName, tsig.GSS, 300, time.Now().Unix())
+
+	c := new(dns.Client)
+	c.TsigProvider = p.client
+
+	in, _, err := c.ExchangeContext(ctx, msg, p.Server)
+	if err != nil {
+		return fmt.Errorf("error processing records via %s: %s", p.Server, err)
+	}
+
+	if in.Opcode != dns.OpcodeUpdate || in.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf(
+			"update response was unsuccessful (opcode=%d, rcode=%d)",
+			in.Opcode, in.Rcode)
+	}
+
+	return nil
+}
@@ -0,0 +1,138 @@
+// Package route53 implements a tlsa.Provider that applies TLSA updates to a
+// zone hosted in AWS Route 53, for deployments that don't run a classic
+// TSIG-speaking hidden master.
+package route53
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/miekg/dns"
+)
+
+// Provider translates the Ns section of a Dynamic Update message into a
+// Route 53 ChangeResourceRecordSets call.
+type Provider struct {
+	// HostedZoneID is the Route 53 hosted zone ID the records live in.
+	HostedZoneID string
+
+	// TTL is used for upserted records, in seconds.
+	TTL int64
+
+	svc *route53.Route53
+}
+
+// NewProvider builds a Provider backed by the default AWS session, picking
+// up credentials and region the same way the AWS CLI does.
+func NewProvider(hostedZoneID string, ttl int64) (*Provider, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %s", err)
+	}
+
+	return &Provider{
+		HostedZoneID: hostedZoneID,
+		TTL:          ttl,
+		svc:          route53.New(sess),
+	}, nil
+}
+
+// Apply implements tlsa.Provider. zone is ignored in favor of
+// HostedZoneID, since Route 53 hosted zones are addressed by ID rather
+// than by name.
+func (p *Provider) Apply(ctx context.Context, zone string, msg *dns.Msg) error {
+	upserts := make(map[string][]*route53.ResourceRecord)
+	deletes := make(map[string]bool)
+
+	for _, rr := range msg.Ns {
+		t, ok := rr.(*dns.TLSA)
+		if !ok {
+			continue
+		}
+
+		if rr.Header().Class == dns.ClassNONE || rr.Header().Class == dns.ClassANY {
+			deletes[t.Hdr.Name] = true
+			continue
+		}
+
+		upserts[t.Hdr.Name] = append(upserts[t.Hdr.Name], &route53.ResourceRecord{
+			Value: aws.String(fmt.Sprintf("%d %d %d %s",
+				t.Usage, t.Selector, t.MatchingType, t.Certificate)),
+		})
+	}
+
+	changes := make([]*route53.Change, 0, len(upserts)+len(deletes))
+
+	for name, records := range upserts {
+		changes = append(changes, &route53.Change{
+			Action: aws.String(route53.ChangeActionUpsert),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            aws.String("TLSA"),
+				TTL:             aws.Int64(p.TTL),
+				ResourceRecords: records,
+			},
+		})
+	}
+
+	for name := range deletes {
+		existing, err := p.existingRecordSet(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		if existing == nil {
+			continue
+		}
+
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: existing,
+		})
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	_, err := p.svc.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(p.HostedZoneID),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to apply Route 53 change batch: %s", err)
+	}
+
+	return nil
+}
+
+// existingRecordSet looks up the TLSA record set currently published for
+// name, returning nil if none exists. Route 53 rejects a DELETE change
+// whose record set doesn't exactly match what's currently published, so
+// the delete path needs the live TTL and ResourceRecords rather than just
+// the name and type.
+func (p *Provider) existingRecordSet(ctx context.Context, name string) (*route53.ResourceRecordSet, error) {
+	out, err := p.svc.ListResourceRecordSetsWithContext(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(p.HostedZoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: aws.String("TLSA"),
+		MaxItems:        aws.String("1"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up Route 53 record set for %s: %s", name, err)
+	}
+
+	if len(out.ResourceRecordSets) == 0 {
+		return nil, nil
+	}
+
+	rrset := out.ResourceRecordSets[0]
+	if aws.StringValue(rrset.Name) != dns.Fqdn(name) || aws.StringValue(rrset.Type) != "TLSA" {
+		return nil, nil
+	}
+
+	return rrset, nil
+}
@@ -0,0 +1,250 @@
+package tlsa
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// RolloverTTL is the fallback wait, in seconds, used between the add and
+// remove phases of RolloverRRs() when neither the existing TLSA RRset's TTL
+// nor the zone's SOA minimum can be observed.
+var RolloverTTL = uint32(3600)
+
+// rolloverState tracks a single domain's in-flight rollover, so that the
+// waiting phase between the additive and subtractive updates can survive a
+// process restart -- an operator driving RolloverRRs() from cron converges
+// safely across runs instead of losing track of which signatures are still
+// safe to remove.
+type rolloverState struct {
+	Domain   string   `json:"domain"`
+	OldSigs  []string `json:"old_sigs"`
+	NewSigs  []string `json:"new_sigs"`
+	RemoveAt int64    `json:"remove_at"`
+}
+
+// rolloverFile is the on-disk representation of the pending rollovers.
+type rolloverFile struct {
+	Pending []rolloverState `json:"pending"`
+}
+
+func loadRolloverFile(stateFile string) (*rolloverFile, error) {
+	f := &rolloverFile{}
+
+	b, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollover state file %s: %s",
+			stateFile, err)
+	}
+
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("failed to parse rollover state file %s: %s",
+			stateFile, err)
+	}
+
+	return f, nil
+}
+
+func (f *rolloverFile) save(stateFile string) error {
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode rollover state: %s", err)
+	}
+
+	if err := ioutil.WriteFile(stateFile, b, 0600); err != nil {
+		return fmt.Errorf("failed to write rollover state file %s: %s",
+			stateFile, err)
+	}
+
+	return nil
+}
+
+// existingTLSA queries NameServer for the current TLSA RRset of domain,
+// returning the hex signatures found and the RRset's TTL, if any.
+func existingTLSA(ctx context.Context, domain string) ([]string, uint32, error) {
+	c := newDNSClient()
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeTLSA)
+	m.SetEdns0(UDPBUFSIZE, true)
+
+	in, _, err := c.ExchangeContext(ctx, m, NameServer)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying TLSA RRset for %s via %s: %s",
+			domain, NameServer, err)
+	}
+
+	sigs := make([]string, 0, len(in.Answer))
+	var ttl uint32
+
+	for _, rr := range in.Answer {
+		if t, ok := rr.(*dns.TLSA); ok {
+			sigs = append(sigs, t.Certificate)
+			ttl = t.Hdr.Ttl
+		}
+	}
+
+	return sigs, ttl, nil
+}
+
+// soaMinimum returns the SOA minimum field for domain's zone, for use as a
+// wait interval when the TLSA RRset's own TTL can't be observed (e.g. it
+// doesn't exist yet).
+func soaMinimum(ctx context.Context, domain string) (uint32, error) {
+	c := newDNSClient()
+
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(domain), dns.TypeSOA)
+	m.SetEdns0(UDPBUFSIZE, true)
+
+	in, _, err := c.ExchangeContext(ctx, m, NameServer)
+	if err != nil {
+		return 0, fmt.Errorf("error querying SOA for %s via %s: %s",
+			domain, NameServer, err)
+	}
+
+	for _, rr := range append(in.Answer, in.Ns...) {
+		if soa, ok := rr.(*dns.SOA); ok {
+			return soa.Minttl, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no SOA record found for %s", domain)
+}
+
+func stringsContain(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
+
+// RolloverRRs safely transitions the TLSA RRset for each of pinNames to
+// newSigs, following the RFC 7671 "3 3 1" rollover pattern: the new
+// signatures are added alongside the existing ones, and only once the old
+// RRset's TTL has elapsed are the stale signatures removed. This avoids the
+// window where a DANE-validating client has cached an RRset that no longer
+// matches the live certificate, which the destructive clear-and-add
+// approach in DeleteRRs()/AddRR() cannot avoid.
+//
+// Progress is persisted to stateFile, so that calling RolloverRRs() again
+// (e.g. from cron) resumes any rollover still waiting out its TTL and
+// starts new ones for domains not already pending. ctx bounds every DNS
+// lookup and update RolloverRRs performs, the same as AddRR()/DeleteRRs().
+func RolloverRRs(ctx context.Context, pinNames []string, provider Provider, newSigs []string, stateFile string) error {
+	state, err := loadRolloverFile(stateFile)
+	if err != nil {
+		return err
+	}
+
+	pending := make(map[string]*rolloverState, len(state.Pending))
+	for i := range state.Pending {
+		pending[state.Pending[i].Domain] = &state.Pending[i]
+	}
+
+	now := time.Now().Unix()
+
+	for _, domain := range pinNames {
+		if p, ok := pending[domain]; ok {
+			if now < p.RemoveAt {
+				continue
+			}
+
+			if err := removeStaleSignatures(ctx, domain, provider, p.OldSigs, p.NewSigs); err != nil {
+				return err
+			}
+
+			delete(pending, domain)
+			continue
+		}
+
+		oldSigs, ttl, err := existingTLSA(ctx, domain)
+		if err != nil {
+			return err
+		}
+
+		if ttl == 0 {
+			ttl, err = soaMinimum(ctx, domain)
+			if err != nil || ttl == 0 {
+				ttl = RolloverTTL
+			}
+		}
+
+		toAdd := make([]string, 0, len(newSigs))
+		for _, sig := range newSigs {
+			if !stringsContain(oldSigs, sig) {
+				toAdd = append(toAdd, sig)
+			}
+		}
+
+		if len(toAdd) != 0 {
+			if err := AddRR(ctx, []string{domain}, provider, toAdd, 1); err != nil {
+				return err
+			}
+		}
+
+		pending[domain] = &rolloverState{
+			Domain:   domain,
+			OldSigs:  oldSigs,
+			NewSigs:  newSigs,
+			RemoveAt: now + int64(ttl),
+		}
+	}
+
+	state.Pending = state.Pending[:0]
+	for _, p := range pending {
+		state.Pending = append(state.Pending, *p)
+	}
+
+	return state.save(stateFile)
+}
+
+// removeStaleSignatures issues the subtractive half of a rollover, deleting
+// only the signatures from oldSigs that are not present in newSigs.
+func removeStaleSignatures(ctx context.Context, domain string, provider Provider, oldSigs, newSigs []string) error {
+	stale := make([]string, 0, len(oldSigs))
+	for _, sig := range oldSigs {
+		if !stringsContain(newSigs, sig) {
+			stale = append(stale, sig)
+		}
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	zone, err := GetZone(ctx, domain, NameServer)
+	if err != nil {
+		return err
+	}
+
+	records := make([]dns.RR, 0, len(stale))
+
+	for _, sig := range stale {
+		rTLSA := new(dns.TLSA)
+		rTLSA.Hdr.Name = dns.Fqdn(domain)
+		rTLSA.Hdr.Rrtype = dns.TypeTLSA
+		rTLSA.Hdr.Class = dns.ClassINET
+		rTLSA.Usage = uint8(Usage)
+		rTLSA.Selector = uint8(Selector)
+		rTLSA.MatchingType = uint8(MatchingType)
+		rTLSA.Certificate = sig
+
+		records = append(records, rTLSA)
+	}
+
+	m := new(dns.Msg)
+	m.SetUpdate(zone)
+	m.Remove(records)
+
+	return provider.Apply(ctx, zone, m)
+}
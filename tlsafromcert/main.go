@@ -4,21 +4,60 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
+	"github.com/miekg/dns"
 	"github.com/nerdlem/tlsa"
+	"github.com/nerdlem/tlsa/acme"
+	"github.com/nerdlem/tlsa/providers/cloudflare"
+	"github.com/nerdlem/tlsa/providers/file"
+	"github.com/nerdlem/tlsa/providers/gsstsig"
+	"github.com/nerdlem/tlsa/providers/route53"
+	"golang.org/x/crypto/acme/autocert"
+	"log"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 )
 
 var certPinFiles, namesList, tsigKeyFile, tsigKeyName string
 var clearAll, dryRun bool
 var pinCerts, pinNames, certNames []string
 
+var pinEndpointsList string
+var pinEndpoints []string
+
+var acmeDaemon bool
+var acmeCacheDir, acmeEmail, acmeHTTPAddr string
+
+var rollover bool
+var rolloverStateFile string
+
+var verify bool
+var verifyResolver string
+
+var concurrency int
+var timeout time.Duration
+var tlsSkipVerify bool
+
+var providerName string
+var gssTSIGServer string
+var route53ZoneID string
+var route53TTL int64
+var cloudflareToken, cloudflareZoneID string
+var cloudflareTTL int
+var fileProviderPath string
+
 // Initialize command line flags ahead of time.
 func init() {
 	flag.StringVar(&certPinFiles, "pin-certs", "",
 		"X.509 certificates file to pin via TLSA (comma separated)")
+	flag.StringVar(&pinEndpointsList, "pin-endpoints", "",
+		"Live host:port TLS endpoints to pin via TLSA, for certs that "+
+			"aren't on the local filesystem (comma separated)")
 	flag.StringVar(&tsigKeyFile, "tsig-file", "tsig.key",
 		"TSIG key file")
 	flag.BoolVar(&clearAll, "clear-all", false,
@@ -35,18 +74,95 @@ func init() {
 		"TLSA Matching Type code (see RFC-6698ยง2.1.3")
 	flag.UintVar(&tlsa.Usage, "tlsa-usage", 3,
 		"TLSA Usage code (see RFC-6698ยง2.1.1")
+	flag.BoolVar(&acmeDaemon, "acme", false,
+		"Run as a daemon, obtaining certificates via ACME for --names and "+
+			"keeping their TLSA records in sync (ignores --pin-certs)")
+	flag.StringVar(&acmeCacheDir, "acme-cache-dir", "acme-cache",
+		"Directory where ACME account keys and certificates are cached")
+	flag.StringVar(&acmeEmail, "acme-email", "",
+		"Contact email address to register with the ACME CA")
+	flag.StringVar(&acmeHTTPAddr, "acme-http-addr", ":80",
+		"Address to serve the ACME HTTP-01 challenge responder on; "+
+			"required for Let's Encrypt to validate --names")
+	flag.BoolVar(&rollover, "rollover", false,
+		"Roll the TLSA RRset over to --pin-certs following RFC 7671 instead "+
+			"of the destructive clear-and-add path")
+	flag.StringVar(&rolloverStateFile, "rollover-state", "rollover.json",
+		"State file used to track in-flight rollovers across runs")
+	flag.BoolVar(&verify, "verify", false,
+		"Compare the live TLSA RRset for --names against --pin-certs/"+
+			"--pin-endpoints and report discrepancies, without changing "+
+			"anything (exits non-zero if any are found)")
+	flag.StringVar(&verifyResolver, "verify-resolver", "",
+		"Resolver (host:port) to query for --verify; defaults to --ns")
+	flag.StringVar(&providerName, "provider", "tsig",
+		"Update backend to use: tsig, gsstsig, route53, cloudflare, file")
+	flag.StringVar(&gssTSIGServer, "gsstsig-server", "",
+		"DNS server (host:port) to negotiate a GSS-TSIG context with "+
+			"(--provider=gsstsig)")
+	flag.StringVar(&route53ZoneID, "route53-zone", "",
+		"Route 53 hosted zone ID (--provider=route53)")
+	flag.Int64Var(&route53TTL, "route53-ttl", 300,
+		"TTL, in seconds, for records upserted via Route 53 (--provider=route53)")
+	flag.StringVar(&cloudflareToken, "cloudflare-token", "",
+		"Cloudflare API token (--provider=cloudflare)")
+	flag.StringVar(&cloudflareZoneID, "cloudflare-zone", "",
+		"Cloudflare zone ID (--provider=cloudflare)")
+	flag.IntVar(&cloudflareTTL, "cloudflare-ttl", 1,
+		"TTL, in seconds, for records upserted via Cloudflare (1 means "+
+			"automatic) (--provider=cloudflare)")
+	flag.StringVar(&fileProviderPath, "file-path", "nsupdate.txt",
+		"File to append the nsupdate(1) script equivalent to "+
+			"(--provider=file)")
+	flag.IntVar(&concurrency, "concurrency", 4,
+		"Number of domains to process in parallel")
+	flag.DurationVar(&timeout, "timeout", time.Minute,
+		"Overall deadline for looking up and updating all of --names")
+	flag.StringVar(&tlsa.Transport, "transport", "udp",
+		"Network used to talk to --ns and the resolver: udp, tcp or tcp-tls")
+	flag.BoolVar(&tlsSkipVerify, "transport-tls-skip-verify", false,
+		"Skip certificate verification when --transport=tcp-tls")
+}
+
+// newProvider builds the tlsa.Provider selected via --provider, wiring in
+// whichever provider-specific flags apply.
+func newProvider(keys []dns.KEY) (tlsa.Provider, error) {
+	switch providerName {
+	case "", "tsig":
+		return tlsa.TSIGProvider{Keys: keys}, nil
+	case "gsstsig":
+		if gssTSIGServer == "" {
+			return nil, fmt.Errorf("--gsstsig-server is required for --provider=gsstsig")
+		}
+		return gsstsig.NewProvider(gssTSIGServer)
+	case "route53":
+		if route53ZoneID == "" {
+			return nil, fmt.Errorf("--route53-zone is required for --provider=route53")
+		}
+		return route53.NewProvider(route53ZoneID, route53TTL)
+	case "cloudflare":
+		if cloudflareToken == "" || cloudflareZoneID == "" {
+			return nil, fmt.Errorf(
+				"--cloudflare-token and --cloudflare-zone are required for --provider=cloudflare")
+		}
+		return cloudflare.NewProvider(cloudflareToken, cloudflareZoneID, cloudflareTTL)
+	case "file":
+		return file.Provider{Path: fileProviderPath}, nil
+	default:
+		return nil, fmt.Errorf("unknown provider %q", providerName)
+	}
 }
 
 func main() {
 	flag.Parse()
 
-	// Read the TSIG key file to prepare the dynamic updates
-
-	m, err := tlsa.ReadTSIG(tsigKeyFile)
-	if err != nil {
-		panic(fmt.Sprintf("Error processing TSIG key file: %s", err))
+	if tlsa.Transport == "tcp-tls" && tlsSkipVerify {
+		tlsa.TLSConfig = &tls.Config{InsecureSkipVerify: true}
 	}
 
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
 	// Compose the domain lists we'll be working with based on the
 	// certificates and the command line options.
 
@@ -61,11 +177,18 @@ func main() {
 		pinCerts = append(pinCerts, strings.Split(certPinFiles, ",")...)
 	}
 
+	pinEndpoints = make([]string, 0, 1)
+
+	if pinEndpointsList != "" {
+		pinEndpoints = append(pinEndpoints, strings.Split(pinEndpointsList, ",")...)
+	}
+
 	if len(pinNames) == 0 {
 		panic("No pinned-names to work with. Use --names")
 	}
 
 	var crtSigns []string
+	var err error
 
 	if len(pinCerts) != 0 {
 		crtSigns, err = tlsa.CertificateSignatures(pinCerts)
@@ -74,19 +197,92 @@ func main() {
 		}
 	}
 
+	if len(pinEndpoints) != 0 {
+		endpointSigns, err := tlsa.CertificateSignaturesFromEndpoints(pinEndpoints)
+		if err != nil {
+			panic(err)
+		}
+		crtSigns = append(crtSigns, endpointSigns...)
+	}
+
+	if verify {
+		resolver := verifyResolver
+		if resolver == "" {
+			resolver = tlsa.NameServer
+		}
+
+		discrepancies, err := tlsa.VerifyRRs(ctx, pinNames, crtSigns, resolver)
+		if err != nil {
+			panic(err)
+		}
+
+		for _, d := range discrepancies {
+			fmt.Println(d.String())
+		}
+
+		if len(discrepancies) != 0 {
+			os.Exit(1)
+		}
+
+		os.Exit(0)
+	}
+
+	// Read the TSIG key file to prepare the dynamic updates, but only for
+	// the provider that actually speaks TSIG -- --verify above never
+	// reaches this point, and the other --provider backends have no use
+	// for a tsig.key either.
+
+	var m []dns.KEY
+
+	if providerName == "" || providerName == "tsig" {
+		m, err = tlsa.ReadTSIG(tsigKeyFile)
+		if err != nil {
+			panic(fmt.Sprintf("Error processing TSIG key file: %s", err))
+		}
+	}
+
+	if acmeDaemon {
+		acmeProvider, err := newProvider(m)
+		if err != nil {
+			panic(err)
+		}
+
+		mgr := acme.NewManager(autocert.HostWhitelist(pinNames...),
+			acmeCacheDir, acmeEmail, acmeProvider)
+
+		go func() {
+			if err := http.ListenAndServe(acmeHTTPAddr, mgr.HTTPHandler(nil)); err != nil {
+				log.Fatalf("acme: HTTP-01 challenge listener on %s failed: %s",
+					acmeHTTPAddr, err)
+			}
+		}()
+
+		mgr.Watch(pinNames)
+		os.Exit(0)
+	}
+
 	if dryRun {
 		for _, k := range m {
 			fmt.Printf("dry-run: Will use TSIG key %s\n", k.PublicKey)
 		}
 	}
 
+	var provider tlsa.Provider
+
+	if clearAll || len(crtSigns) != 0 {
+		provider, err = newProvider(m)
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	if clearAll {
 		if dryRun {
 			for _, n := range pinNames {
 				fmt.Printf("dry-run: Clear all TLSA RRs for %s\n", n)
 			}
-		} else {
-			tlsa.DeleteRRs(pinNames, m)
+		} else if err := tlsa.DeleteRRs(ctx, pinNames, provider, concurrency); err != nil {
+			panic(err)
 		}
 	}
 
@@ -98,8 +294,12 @@ func main() {
 						n, s)
 				}
 			}
-		} else {
-			tlsa.AddRR(pinNames, m, crtSigns)
+		} else if rollover {
+			if err := tlsa.RolloverRRs(ctx, pinNames, provider, crtSigns, rolloverStateFile); err != nil {
+				panic(err)
+			}
+		} else if err := tlsa.AddRR(ctx, pinNames, provider, crtSigns, concurrency); err != nil {
+			panic(err)
 		}
 	}
 
@@ -0,0 +1,132 @@
+package tlsa
+
+import (
+	"context"
+	"fmt"
+	"github.com/miekg/dns"
+)
+
+// DiscrepancyKind classifies the way a live TLSA record differs from what
+// was expected.
+type DiscrepancyKind int
+
+const (
+	// Unexpected marks a record present in DNS but not in the expected
+	// signature list.
+	Unexpected DiscrepancyKind = iota
+
+	// Missing marks an expected signature that isn't published in DNS.
+	Missing
+
+	// ParameterMismatch marks a record whose Usage/Selector/MatchingType
+	// triple doesn't match the current global configuration.
+	ParameterMismatch
+)
+
+func (k DiscrepancyKind) String() string {
+	switch k {
+	case Unexpected:
+		return "unexpected"
+	case Missing:
+		return "missing"
+	case ParameterMismatch:
+		return "parameter-mismatch"
+	default:
+		return "unknown"
+	}
+}
+
+// Discrepancy describes one way the live TLSA RRset for Domain differs from
+// what was expected.
+type Discrepancy struct {
+	Domain    string
+	Kind      DiscrepancyKind
+	Signature string
+}
+
+func (d Discrepancy) String() string {
+	switch d.Kind {
+	case Unexpected:
+		return fmt.Sprintf("%s: unexpected record in DNS: %s", d.Domain, d.Signature)
+	case Missing:
+		return fmt.Sprintf("%s: expected record missing from DNS: %s", d.Domain, d.Signature)
+	case ParameterMismatch:
+		return fmt.Sprintf(
+			"%s: record %s has a Usage/Selector/MatchingType triple that "+
+				"doesn't match the current configuration", d.Domain, d.Signature)
+	default:
+		return fmt.Sprintf("%s: unknown discrepancy", d.Domain)
+	}
+}
+
+// VerifyRRs queries resolver (NameServer when empty) for the live TLSA
+// RRset of each of pinNames and compares it against expectedSigs, without
+// requiring TSIG. It reports records present in DNS but not expected,
+// records expected but missing, and records whose Usage/Selector/
+// MatchingType triple doesn't match the current global Usage/Selector/
+// MatchingType, so drift introduced by out-of-band zone edits can be
+// detected after the fact. ctx bounds every lookup, the same as
+// AddRR()/GetZone(), so a monitoring pipeline calling VerifyRRs on a
+// schedule can't hang against an unresponsive resolver.
+func VerifyRRs(ctx context.Context, pinNames []string, expectedSigs []string, resolver string) ([]Discrepancy, error) {
+	if resolver == "" {
+		resolver = NameServer
+	}
+
+	var discrepancies []Discrepancy
+
+	c := newDNSClient()
+
+	for _, domain := range pinNames {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(domain), dns.TypeTLSA)
+		m.SetEdns0(UDPBUFSIZE, true)
+
+		in, _, err := c.ExchangeContext(ctx, m, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("error querying TLSA RRset for %s via %s: %s",
+				domain, resolver, err)
+		}
+
+		seen := make(map[string]bool, len(in.Answer))
+
+		for _, rr := range in.Answer {
+			t, ok := rr.(*dns.TLSA)
+			if !ok {
+				continue
+			}
+
+			seen[t.Certificate] = true
+
+			if !stringsContain(expectedSigs, t.Certificate) {
+				discrepancies = append(discrepancies, Discrepancy{
+					Domain:    domain,
+					Kind:      Unexpected,
+					Signature: t.Certificate,
+				})
+				continue
+			}
+
+			if t.Usage != uint8(Usage) || t.Selector != uint8(Selector) ||
+				t.MatchingType != uint8(MatchingType) {
+				discrepancies = append(discrepancies, Discrepancy{
+					Domain:    domain,
+					Kind:      ParameterMismatch,
+					Signature: t.Certificate,
+				})
+			}
+		}
+
+		for _, sig := range expectedSigs {
+			if !seen[sig] {
+				discrepancies = append(discrepancies, Discrepancy{
+					Domain:    domain,
+					Kind:      Missing,
+					Signature: sig,
+				})
+			}
+		}
+	}
+
+	return discrepancies, nil
+}